@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Module describes how to scrape a single PowerDNS target: which stats
+// definitions to use, the API key to present and how long to wait for a
+// response. It is referenced by name from /probe?module=<name>.
+type Module struct {
+	ServerType string        `yaml:"server_type"`
+	ApiKey     string        `yaml:"api_key"`
+	Timeout    time.Duration `yaml:"timeout"`
+	TLSConfig  TLSConfig     `yaml:"tls_config"`
+}
+
+// TLSConfig controls certificate verification when a module's target is
+// scraped over HTTPS.
+type TLSConfig struct {
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
+// Config is the top-level structure of the --config.file YAML document.
+type Config struct {
+	Modules map[string]Module `yaml:"modules"`
+}
+
+// withDefaultModule ensures cfg has a "default" module, falling back to
+// defaultModule when cfg doesn't define one of its own. This keeps the
+// -api-key/-server-type flags usable as a zero-config default even when
+// --config.file is set, instead of the loaded file silently replacing it.
+func withDefaultModule(cfg *Config, defaultModule Module) *Config {
+	if cfg.Modules == nil {
+		cfg.Modules = map[string]Module{}
+	}
+	if _, ok := cfg.Modules["default"]; !ok {
+		cfg.Modules["default"] = defaultModule
+	}
+	return cfg
+}
+
+// LoadConfig reads and parses a module configuration file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
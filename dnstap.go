@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/golang/protobuf/proto"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+const dnstapSubsystem = "dnstap"
+
+// maxPendingQueryAge bounds how long a CLIENT_QUERY frame is kept waiting
+// for its matching CLIENT_RESPONSE. Queries whose response is dropped,
+// times out, or never arrives would otherwise accumulate in pending for
+// the life of the process on a busy resolver or dnsdist.
+const maxPendingQueryAge = 10 * time.Second
+
+// pendingQuery is the state kept for a CLIENT_QUERY frame until its matching
+// CLIENT_RESPONSE frame arrives so the two can be joined on latency.
+type pendingQuery struct {
+	queryTime time.Time
+}
+
+// DnstapCollector consumes a dnstap (http://dnstap.info) framestream produced
+// by the PowerDNS recursor or dnsdist and exposes per-query Prometheus
+// metrics. It implements prometheus.Collector so it can be registered
+// alongside the regular scrape-based Exporter.
+type DnstapCollector struct {
+	listenAddr string
+
+	qtypeAllowlist map[string]bool
+	rcodeAllowlist map[string]bool
+	subnetMode     string
+
+	mutex   sync.Mutex
+	pending map[string]pendingQuery
+
+	up               prometheus.Gauge
+	framesTotal      prometheus.Counter
+	decodeFailures   prometheus.Counter
+	pendingEvictions prometheus.Counter
+	queriesTotal     *prometheus.CounterVec
+	latency          *prometheus.HistogramVec
+}
+
+// NewDnstapCollector returns an initialized DnstapCollector listening on
+// listenAddr, which is either a filesystem path (unix socket) or a
+// "host:port" TCP address. qtypeAllowlist and rcodeAllowlist bound label
+// cardinality: values not present in the list are reported under "other".
+// An empty list allows everything through unmodified.
+func NewDnstapCollector(listenAddr string, qtypeAllowlist, rcodeAllowlist []string, subnetMode string) *DnstapCollector {
+	c := &DnstapCollector{
+		listenAddr:     listenAddr,
+		qtypeAllowlist: toSet(qtypeAllowlist),
+		rcodeAllowlist: toSet(rcodeAllowlist),
+		subnetMode:     subnetMode,
+		pending:        make(map[string]pendingQuery),
+		up: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: dnstapSubsystem,
+			Name:      "up",
+			Help:      "Whether the dnstap listener is accepting connections.",
+		}),
+		framesTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: dnstapSubsystem,
+			Name:      "frames_total",
+			Help:      "Total number of dnstap frames received.",
+		}),
+		decodeFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: dnstapSubsystem,
+			Name:      "decode_failures_total",
+			Help:      "Total number of dnstap frames that could not be decoded.",
+		}),
+		pendingEvictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: dnstapSubsystem,
+			Name:      "pending_query_evictions_total",
+			Help:      "Total number of CLIENT_QUERY frames evicted after waiting longer than the max pending age for a response.",
+		}),
+		queriesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: dnstapSubsystem,
+			Name:      "queries_total",
+			Help:      "Total number of queries observed via dnstap.",
+		}, []string{"qtype", "rcode", "client_subnet"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: dnstapSubsystem,
+			Name:      "query_duration_seconds",
+			Help:      "Time between a CLIENT_QUERY and its matching CLIENT_RESPONSE frame.",
+			Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"qtype"}),
+	}
+	c.up.Set(0)
+	return c
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[strings.ToUpper(v)] = true
+	}
+	return set
+}
+
+// Describe implements prometheus.Collector.
+func (c *DnstapCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.up.Desc()
+	ch <- c.framesTotal.Desc()
+	ch <- c.decodeFailures.Desc()
+	ch <- c.pendingEvictions.Desc()
+	c.queriesTotal.Describe(ch)
+	c.latency.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *DnstapCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- c.up
+	ch <- c.framesTotal
+	ch <- c.decodeFailures
+	ch <- c.pendingEvictions
+	c.queriesTotal.Collect(ch)
+	c.latency.Collect(ch)
+}
+
+// Run starts accepting dnstap connections on the configured listen address
+// and blocks until the listener fails. It is meant to be run in its own
+// goroutine for the lifetime of the process.
+func (c *DnstapCollector) Run() error {
+	ln, err := c.listen()
+	if err != nil {
+		return fmt.Errorf("could not start dnstap listener: %v", err)
+	}
+	defer ln.Close()
+
+	go c.sweepPendingLoop()
+
+	c.up.Set(1)
+	log.Infof("Dnstap listener accepting connections on %s", c.listenAddr)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			c.up.Set(0)
+			return err
+		}
+		go c.handleConn(conn)
+	}
+}
+
+// sweepPendingLoop periodically evicts stale pending queries for the life
+// of the process.
+func (c *DnstapCollector) sweepPendingLoop() {
+	ticker := time.NewTicker(maxPendingQueryAge)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		c.sweepPending(now)
+	}
+}
+
+// sweepPending evicts pending queries whose CLIENT_RESPONSE never arrived
+// within maxPendingQueryAge of the CLIENT_QUERY.
+func (c *DnstapCollector) sweepPending(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, p := range c.pending {
+		if now.Sub(p.queryTime) > maxPendingQueryAge {
+			delete(c.pending, key)
+			c.pendingEvictions.Inc()
+		}
+	}
+}
+
+func (c *DnstapCollector) listen() (net.Listener, error) {
+	if strings.HasPrefix(c.listenAddr, "/") {
+		return net.Listen("unix", c.listenAddr)
+	}
+	return net.Listen("tcp", c.listenAddr)
+}
+
+func (c *DnstapCollector) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	input, err := dnstap.NewFrameStreamInput(conn, true)
+	if err != nil {
+		log.Errorf("Could not negotiate dnstap framestream handshake: %v", err)
+		return
+	}
+
+	frames := make(chan []byte)
+	go input.ReadInto(frames)
+
+	for frame := range frames {
+		c.framesTotal.Inc()
+		if err := c.processFrame(frame); err != nil {
+			c.decodeFailures.Inc()
+			log.Errorf("Could not decode dnstap frame: %v", err)
+		}
+	}
+}
+
+func (c *DnstapCollector) processFrame(frame []byte) error {
+	var dt dnstap.Dnstap
+	if err := proto.Unmarshal(frame, &dt); err != nil {
+		return err
+	}
+	msg := dt.Message
+	if msg == nil {
+		return fmt.Errorf("dnstap frame carries no message")
+	}
+
+	// Only CLIENT_QUERY/CLIENT_RESPONSE are joined into queriesTotal and
+	// the latency histogram: they measure client-to-recursor latency.
+	// RESOLVER_QUERY/RESOLVER_RESPONSE measure a different leg (recursor
+	// to upstream authoritative) and would corrupt that distribution if
+	// merged in; they are intentionally ignored for now.
+	switch msg.GetType() {
+	case dnstap.Message_CLIENT_QUERY:
+		c.trackQuery(msg)
+	case dnstap.Message_CLIENT_RESPONSE:
+		c.trackResponse(msg)
+	}
+	return nil
+}
+
+func (c *DnstapCollector) trackQuery(msg *dnstap.Message) {
+	qtype, _, _ := parseDNSMessage(msg.GetQueryMessage())
+	key := messageKey(msg.GetQueryMessage(), msg.GetQueryAddress())
+
+	c.mutex.Lock()
+	c.pending[key] = pendingQuery{queryTime: protoTime(msg.GetQueryTimeSec(), msg.GetQueryTimeNsec())}
+	c.mutex.Unlock()
+
+	_ = qtype
+}
+
+func (c *DnstapCollector) trackResponse(msg *dnstap.Message) {
+	qtype, rcode, clientSubnet := parseDNSMessage(msg.GetResponseMessage())
+	key := messageKey(msg.GetResponseMessage(), msg.GetQueryAddress())
+
+	c.mutex.Lock()
+	pending, ok := c.pending[key]
+	if ok {
+		delete(c.pending, key)
+	}
+	c.mutex.Unlock()
+
+	subnetAddr := []byte(msg.GetQueryAddress())
+	if ip := net.ParseIP(clientSubnet); ip != nil {
+		subnetAddr = ip
+	}
+	clientSubnet = c.labelSubnet(subnetAddr)
+
+	c.queriesTotal.WithLabelValues(c.qtypeLabel(qtype), c.rcodeLabel(rcode), clientSubnet).Inc()
+
+	if ok {
+		responseTime := protoTime(msg.GetResponseTimeSec(), msg.GetResponseTimeNsec())
+		c.latency.WithLabelValues(c.qtypeLabel(qtype)).Observe(responseTime.Sub(pending.queryTime).Seconds())
+	}
+}
+
+// parseDNSMessage extracts qtype, rcode and any EDNS client-subnet option
+// from a wire-format DNS message. It returns empty values if the message
+// cannot be parsed.
+func parseDNSMessage(wire []byte) (qtype, rcode, clientSubnet string) {
+	if len(wire) == 0 {
+		return "", "", ""
+	}
+	m := new(dns.Msg)
+	if err := m.Unpack(wire); err != nil {
+		return "", "", ""
+	}
+	if len(m.Question) > 0 {
+		qtype = dns.TypeToString[m.Question[0].Qtype]
+	}
+	rcode = dns.RcodeToString[m.Rcode]
+	for _, extra := range m.Extra {
+		opt, ok := extra.(*dns.OPT)
+		if !ok {
+			continue
+		}
+		for _, o := range opt.Option {
+			if subnet, ok := o.(*dns.EDNS0_SUBNET); ok {
+				clientSubnet = subnet.Address.String()
+			}
+		}
+	}
+	return
+}
+
+// messageKey joins a CLIENT_QUERY with its CLIENT_RESPONSE on DNS message ID
+// and the client's IP address (the query port is not part of the key).
+func messageKey(wire []byte, addr []byte) string {
+	var id uint16
+	if len(wire) >= 2 {
+		id = binary.BigEndian.Uint16(wire[0:2])
+	}
+	return fmt.Sprintf("%x-%d", addr, id)
+}
+
+func protoTime(sec uint64, nsec uint32) time.Time {
+	return time.Unix(int64(sec), int64(nsec))
+}
+
+func (c *DnstapCollector) qtypeLabel(qtype string) string {
+	if qtype == "" {
+		return "other"
+	}
+	if len(c.qtypeAllowlist) == 0 || c.qtypeAllowlist[strings.ToUpper(qtype)] {
+		return qtype
+	}
+	return "other"
+}
+
+func (c *DnstapCollector) rcodeLabel(rcode string) string {
+	if rcode == "" {
+		return "other"
+	}
+	if len(c.rcodeAllowlist) == 0 || c.rcodeAllowlist[strings.ToUpper(rcode)] {
+		return rcode
+	}
+	return "other"
+}
+
+// labelSubnet reduces a client IP address to a bounded-cardinality label
+// according to the collector's subnet mode: "truncate" keeps the /24 (v4)
+// or /48 (v6) network, anything else hashes the full address with FNV-1a.
+func (c *DnstapCollector) labelSubnet(addr []byte) string {
+	ip := net.IP(addr)
+	if ip == nil {
+		return "unknown"
+	}
+	if c.subnetMode == "truncate" {
+		if v4 := ip.To4(); v4 != nil {
+			return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+		}
+		return (&net.IPNet{IP: ip.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+	}
+	h := fnv.New32a()
+	h.Write(addr)
+	return fmt.Sprintf("%x", h.Sum32())
+}
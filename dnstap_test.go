@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestToSet(t *testing.T) {
+	s := toSet([]string{"a", "B", "a"})
+	if len(s) != 2 || !s["A"] || !s["B"] {
+		t.Errorf("unexpected set: %v", s)
+	}
+	if empty := toSet(nil); len(empty) != 0 {
+		t.Errorf("expected empty set for nil input, got %v", empty)
+	}
+}
+
+func TestQtypeLabel(t *testing.T) {
+	c := NewDnstapCollector("", []string{"A", "AAAA"}, nil, "hash")
+
+	if got := c.qtypeLabel("A"); got != "A" {
+		t.Errorf("expected allowlisted qtype to pass through, got %q", got)
+	}
+	if got := c.qtypeLabel("MX"); got != "other" {
+		t.Errorf("expected non-allowlisted qtype to map to other, got %q", got)
+	}
+	if got := c.qtypeLabel(""); got != "other" {
+		t.Errorf("expected empty qtype to map to other, got %q", got)
+	}
+}
+
+func TestRcodeLabelEmptyAllowlistAllowsEverything(t *testing.T) {
+	c := NewDnstapCollector("", nil, nil, "hash")
+
+	if got := c.rcodeLabel("SERVFAIL"); got != "SERVFAIL" {
+		t.Errorf("expected rcode to pass through with empty allowlist, got %q", got)
+	}
+}
+
+func TestLabelSubnetTruncate(t *testing.T) {
+	c := NewDnstapCollector("", nil, nil, "truncate")
+
+	got := c.labelSubnet(net.ParseIP("203.0.113.77").To4())
+	if got != "203.0.113.0/24" {
+		t.Errorf("expected truncated /24 network, got %q", got)
+	}
+}
+
+func TestLabelSubnetHashIsDeterministic(t *testing.T) {
+	c := NewDnstapCollector("", nil, nil, "hash")
+	addr := net.ParseIP("203.0.113.77").To4()
+
+	if c.labelSubnet(addr) != c.labelSubnet(addr) {
+		t.Error("expected hashed subnet label to be deterministic for the same address")
+	}
+}
+
+func TestMessageKeyDiffersByAddress(t *testing.T) {
+	wire := []byte{0x12, 0x34, 0x00}
+
+	k1 := messageKey(wire, []byte{127, 0, 0, 1})
+	k2 := messageKey(wire, []byte{127, 0, 0, 2})
+	if k1 == k2 {
+		t.Error("expected different keys for different query addresses")
+	}
+}
+
+func TestSweepPendingEvictsOnlyStaleEntries(t *testing.T) {
+	c := NewDnstapCollector("", nil, nil, "hash")
+	now := time.Unix(1000, 0)
+
+	c.pending["stale"] = pendingQuery{queryTime: now.Add(-2 * maxPendingQueryAge)}
+	c.pending["fresh"] = pendingQuery{queryTime: now}
+
+	c.sweepPending(now)
+
+	if _, ok := c.pending["stale"]; ok {
+		t.Error("expected stale pending entry to be evicted")
+	}
+	if _, ok := c.pending["fresh"]; !ok {
+		t.Error("expected fresh pending entry to survive the sweep")
+	}
+	if got := readCounter(c.pendingEvictions); got != 1 {
+		t.Errorf("expected 1 recorded eviction, got %v", got)
+	}
+}
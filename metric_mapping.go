@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io/ioutil"
+	"sync"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// MetricMapping overrides or extends the built-in gaugeDefs/counterVecDefs
+// tables for a single PowerDNS stats key, so operators don't need an
+// exporter rebuild to pick up metrics added by a PowerDNS upgrade.
+type MetricMapping struct {
+	Key      string  `yaml:"key"`
+	Name     string  `yaml:"name"`
+	Help     string  `yaml:"help"`
+	Type     string  `yaml:"type"` // "gauge" (default) or "counter"
+	Label    string  `yaml:"label"`
+	Value    string  `yaml:"label_value"`
+	DivideBy float64 `yaml:"divide_by"`
+}
+
+// MetricMappingConfig is the top-level structure of --metric-mapping-file,
+// keyed by server type (recursor, authoritative, dnsdist).
+type MetricMappingConfig struct {
+	Mappings map[string][]MetricMapping `yaml:"mappings"`
+}
+
+// LoadMetricMappingFile reads and parses a metric mapping file.
+func LoadMetricMappingFile(path string) (*MetricMappingConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &MetricMappingConfig{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// customMappings holds the currently active mapping file contents, if any.
+// It is rebuilt on SIGHUP (see main) so new stats keys surface without an
+// exporter restart.
+var (
+	customMappingsMu sync.RWMutex
+	customMappings   *MetricMappingConfig
+)
+
+func setCustomMappings(cfg *MetricMappingConfig) {
+	customMappingsMu.Lock()
+	defer customMappingsMu.Unlock()
+	customMappings = cfg
+}
+
+func getCustomMappings() *MetricMappingConfig {
+	customMappingsMu.RLock()
+	defer customMappingsMu.RUnlock()
+	return customMappings
+}
+
+// applyCustomMappings layers the active metric mapping file on top of the
+// built-in definitions for serverType: mappings matching an existing gauge
+// key override its name/help, unmatched ones are appended, and "counter"
+// mappings sharing a metric name are grouped into a single CounterVec. It
+// also returns a per-key divisor map for mappings that set divide_by,
+// applied the same way the existing microsecond->second latency conversion
+// is. gaugeDefsIn/counterVecDefsIn are never mutated; copies are returned.
+func applyCustomMappings(serverType string, gaugeDefsIn []gaugeDefinition, counterVecDefsIn []counterVecDefinition) ([]gaugeDefinition, []counterVecDefinition, map[string]float64) {
+	gaugeDefs := append([]gaugeDefinition(nil), gaugeDefsIn...)
+	counterVecDefs := append([]counterVecDefinition(nil), counterVecDefsIn...)
+	divisors := make(map[string]float64)
+
+	cfg := getCustomMappings()
+	if cfg == nil {
+		return gaugeDefs, counterVecDefs, divisors
+	}
+	mappings, ok := cfg.Mappings[serverType]
+	if !ok {
+		return gaugeDefs, counterVecDefs, divisors
+	}
+
+	nextID := 0
+	for _, d := range gaugeDefs {
+		if d.id > nextID {
+			nextID = d.id
+		}
+	}
+	for _, d := range counterVecDefs {
+		if d.id > nextID {
+			nextID = d.id
+		}
+	}
+
+	counterGroups := make(map[string]*counterVecDefinition)
+	for _, m := range mappings {
+		if m.DivideBy != 0 {
+			divisors[m.Key] = m.DivideBy
+		}
+
+		if m.Type == "counter" {
+			group, ok := counterGroups[m.Name]
+			if !ok {
+				nextID++
+				group = &counterVecDefinition{id: nextID, name: m.Name, desc: m.Help, label: m.Label, labelMap: map[string]string{}}
+				counterGroups[m.Name] = group
+			}
+			group.labelMap[m.Key] = m.Value
+			continue
+		}
+
+		replaced := false
+		for i, d := range gaugeDefs {
+			if d.key == m.Key {
+				gaugeDefs[i] = gaugeDefinition{id: d.id, name: m.Name, desc: m.Help, key: m.Key}
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			nextID++
+			gaugeDefs = append(gaugeDefs, gaugeDefinition{id: nextID, name: m.Name, desc: m.Help, key: m.Key})
+		}
+	}
+
+	for _, group := range counterGroups {
+		counterVecDefs = append(counterVecDefs, *group)
+	}
+
+	return gaugeDefs, counterVecDefs, divisors
+}
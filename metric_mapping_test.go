@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestApplyCustomMappingsOverridesAndExtends(t *testing.T) {
+	setCustomMappings(&MetricMappingConfig{
+		Mappings: map[string][]MetricMapping{
+			"recursor": {
+				{Key: "qa-latency", Name: "latency_average_seconds", Help: "overridden", Type: "gauge"},
+				{Key: "new-stat", Name: "new_stat_total", Help: "new", Type: "gauge", DivideBy: 1000},
+				{Key: "custom-counter", Name: "custom_total", Help: "custom counter", Type: "counter", Label: "result", Value: "ok"},
+			},
+		},
+	})
+	defer setCustomMappings(nil)
+
+	gaugeDefs, counterVecDefs, divisors := applyCustomMappings("recursor", recursorGaugeDefs, recursorCounterVecDefs)
+
+	var overrides int
+	for _, d := range gaugeDefs {
+		if d.key == "qa-latency" {
+			overrides++
+			if d.desc != "overridden" {
+				t.Errorf("expected overridden desc for qa-latency, got %q", d.desc)
+			}
+		}
+	}
+	if overrides != 1 {
+		t.Errorf("expected exactly one qa-latency gauge definition, got %d", overrides)
+	}
+
+	var newStatFound bool
+	for _, d := range gaugeDefs {
+		if d.key == "new-stat" {
+			newStatFound = true
+		}
+	}
+	if !newStatFound {
+		t.Error("expected new-stat gauge definition to be appended")
+	}
+	if divisors["new-stat"] != 1000 {
+		t.Errorf("expected divisor 1000 for new-stat, got %v", divisors["new-stat"])
+	}
+
+	var counterFound bool
+	for _, d := range counterVecDefs {
+		if d.name == "custom_total" {
+			counterFound = true
+			if d.labelMap["custom-counter"] != "ok" {
+				t.Errorf("expected label value \"ok\", got %q", d.labelMap["custom-counter"])
+			}
+		}
+	}
+	if !counterFound {
+		t.Error("expected custom_total counter vec definition to be appended")
+	}
+
+	for _, d := range recursorGaugeDefs {
+		if d.key == "qa-latency" && d.desc == "overridden" {
+			t.Fatal("applyCustomMappings must not mutate the shared recursorGaugeDefs slice")
+		}
+	}
+}
+
+func TestApplyCustomMappingsNoneConfigured(t *testing.T) {
+	setCustomMappings(nil)
+
+	gaugeDefs, counterVecDefs, divisors := applyCustomMappings("recursor", recursorGaugeDefs, recursorCounterVecDefs)
+
+	if len(gaugeDefs) != len(recursorGaugeDefs) || len(counterVecDefs) != len(recursorCounterVecDefs) {
+		t.Error("expected defs unchanged when no custom mappings are configured")
+	}
+	if len(divisors) != 0 {
+		t.Error("expected no divisors when no custom mappings are configured")
+	}
+}
+
+func TestCounterVecDivisorIsApplied(t *testing.T) {
+	setCustomMappings(&MetricMappingConfig{
+		Mappings: map[string][]MetricMapping{
+			"recursor": {
+				{Key: "custom-counter-stat", Name: "custom_counter_total", Help: "custom counter", Type: "counter", Label: "result", Value: "ok", DivideBy: 5},
+			},
+		},
+	})
+	defer setCustomMappings(nil)
+
+	config := []byte(`[{"name":"custom-counter-stat","type":"StatisticItem","value":"100"}]`)
+
+	h := newPowerDNS(config)
+	defer h.Close()
+
+	hostURL, _ := url.Parse(h.URL)
+	e := NewExporter("12345", "recursor", hostURL)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("could not gather metrics: %v", err)
+	}
+
+	var found bool
+	for _, fam := range families {
+		if fam.GetName() != "powerdns_recursor_custom_counter_total" {
+			continue
+		}
+		for _, m := range fam.GetMetric() {
+			for _, lp := range m.GetLabel() {
+				if lp.GetName() == "result" && lp.GetValue() == "ok" {
+					found = true
+					if got, want := m.GetCounter().GetValue(), 20.0; got != want {
+						t.Errorf("expected divided counter value %v, got %v", want, got)
+					}
+				}
+			}
+		}
+	}
+	if !found {
+		t.Fatal("expected to find the custom_counter_total series")
+	}
+}
+
+func TestGaugeDivisorOverridesLatencyHeuristic(t *testing.T) {
+	// "my-thing-latency" ends in "latency", which would normally trigger
+	// the built-in microseconds->seconds conversion; an explicit divide_by
+	// must take precedence over that heuristic instead of being ignored.
+	setCustomMappings(&MetricMappingConfig{
+		Mappings: map[string][]MetricMapping{
+			"recursor": {
+				{Key: "my-thing-latency", Name: "my_thing_latency_seconds", Help: "custom gauge", Type: "gauge", DivideBy: 1000},
+			},
+		},
+	})
+	defer setCustomMappings(nil)
+
+	config := []byte(`[{"name":"my-thing-latency","type":"StatisticItem","value":"5000"}]`)
+
+	h := newPowerDNS(config)
+	defer h.Close()
+
+	hostURL, _ := url.Parse(h.URL)
+	e := NewExporter("12345", "recursor", hostURL)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(e)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("could not gather metrics: %v", err)
+	}
+
+	var found bool
+	for _, fam := range families {
+		if fam.GetName() != "powerdns_recursor_my_thing_latency_seconds" {
+			continue
+		}
+		for _, m := range fam.GetMetric() {
+			found = true
+			if got, want := m.GetGauge().GetValue(), 5.0; got != want {
+				t.Errorf("expected divide_by to override the latency heuristic and yield %v, got %v", want, got)
+			}
+		}
+	}
+	if !found {
+		t.Fatal("expected to find the my_thing_latency_seconds series")
+	}
+}
@@ -7,6 +7,52 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// nativeHistogramsEnabled controls whether NewExporter attaches the
+// high-resolution native histograms. When it is false, recursor response
+// times are exposed through the classic fixed-bucket histogram instead.
+// It is set once from the -histogram.native flag before any Exporter is
+// constructed.
+var nativeHistogramsEnabled = true
+
+// nativeHistogramBucketFactor controls the resolution of the native
+// histograms: a factor closer to 1 yields more buckets and more
+// precision. See the NativeHistogramBucketFactor docs in client_golang.
+const nativeHistogramBucketFactor = 1.1
+
+// rTimeMidpoints gives a representative latency (in seconds) for each of
+// the classic recursor answers* counters, used to replay their deltas
+// into the native histogram (see Exporter.observeRTimeDeltas). On their
+// own these midpoints carry no more resolution than the classic 5-bucket
+// histogram; the qa-latency EMA is also observed each scrape to give the
+// native histogram real sub-bucket information.
+var rTimeMidpoints = map[string]float64{
+	"answers0-1":      .0005,
+	"answers1-10":     .005,
+	"answers10-100":   .05,
+	"answers100-1000": .5,
+	"answers-slow":    2,
+}
+
+func newRecursorRTimeNativeHistogram() prometheus.Histogram {
+	return prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace:                   namespace,
+		Subsystem:                   "recursor",
+		Name:                        "response_time_seconds",
+		Help:                        "Histogram of PowerDNS recursor response times in seconds.",
+		NativeHistogramBucketFactor: nativeHistogramBucketFactor,
+	})
+}
+
+func newAuthoritativeLatencyNativeHistogram() prometheus.Histogram {
+	return prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace:                   namespace,
+		Subsystem:                   "authoritative",
+		Name:                        "latency_seconds",
+		Help:                        "Native histogram of the authoritative server's question-to-answer latency EMA, sampled once per scrape.",
+		NativeHistogramBucketFactor: nativeHistogramBucketFactor,
+	})
+}
+
 // Used to programmatically create prometheus.Gauge metrics
 type gaugeDefinition struct {
 	id   int
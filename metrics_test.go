@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestObserveRTimeDeltasUsesQaLatencyAndBucketDeltas(t *testing.T) {
+	hostURL, _ := url.Parse("http://localhost:8081/")
+	e := NewExporter("12345", "recursor", hostURL)
+	if e.rTimeHistogram == nil {
+		t.Fatal("expected recursor exporter to have a native rTimeHistogram")
+	}
+
+	e.observeRTimeDeltas(map[string]float64{
+		"answers0-1":      10,
+		"answers1-10":     5,
+		"answers10-100":   0,
+		"answers100-1000": 0,
+		"answers-slow":    0,
+		"qa-latency":      2500, // microseconds
+	})
+
+	pb := &dto.Metric{}
+	if err := e.rTimeHistogram.Write(pb); err != nil {
+		t.Fatalf("could not write histogram: %v", err)
+	}
+	// 10 + 5 bucket-delta observations plus 1 qa-latency observation.
+	if got, want := pb.GetHistogram().GetSampleCount(), uint64(16); got != want {
+		t.Errorf("expected %d samples after first scrape, got %d", want, got)
+	}
+
+	// A second scrape with unchanged counters plus a new qa-latency
+	// sample should only add the one continuous observation.
+	e.observeRTimeDeltas(map[string]float64{
+		"answers0-1":      10,
+		"answers1-10":     5,
+		"answers10-100":   0,
+		"answers100-1000": 0,
+		"answers-slow":    0,
+		"qa-latency":      3000,
+	})
+
+	pb = &dto.Metric{}
+	if err := e.rTimeHistogram.Write(pb); err != nil {
+		t.Fatalf("could not write histogram: %v", err)
+	}
+	if got, want := pb.GetHistogram().GetSampleCount(), uint64(17); got != want {
+		t.Errorf("expected %d samples after second scrape, got %d", want, got)
+	}
+}
+
+func TestAuthoritativeExporterGetsNativeHistogram(t *testing.T) {
+	hostURL, _ := url.Parse("http://localhost:8081/")
+	e := NewExporter("12345", "authoritative", hostURL)
+	if e.rTimeHistogram == nil {
+		t.Fatal("expected authoritative exporter to have a native rTimeHistogram")
+	}
+}
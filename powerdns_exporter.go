@@ -9,11 +9,15 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"net/url"
+	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/log"
 )
 
@@ -24,21 +28,30 @@ const (
 )
 
 var (
-	client = &http.Client{
+	client = newHTTPClient(5 * time.Second)
+)
+
+// newHTTPClient returns an http.Client whose dial and deadline are both
+// bounded by timeout, falling back to a sane default if timeout is unset.
+func newHTTPClient(timeout time.Duration) *http.Client {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &http.Client{
 		Transport: &http.Transport{
 			Dial: func(netw, addr string) (net.Conn, error) {
-				c, err := net.DialTimeout(netw, addr, 5*time.Second)
+				c, err := net.DialTimeout(netw, addr, timeout)
 				if err != nil {
 					return nil, err
 				}
-				if err := c.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+				if err := c.SetDeadline(time.Now().Add(timeout)); err != nil {
 					return nil, err
 				}
 				return c, nil
 			},
 		},
 	}
-)
+}
 
 // ServerInfo is used to parse JSON data from 'server/localhost' endpoint
 type ServerInfo struct {
@@ -74,6 +87,22 @@ type Exporter struct {
 	gaugeDefs         []gaugeDefinition
 	counterVecDefs    []counterVecDefinition
 	client            *http.Client
+
+	// rTimeHistogram, when non-nil, is a native high-resolution histogram
+	// of response times (recursor) or latency (authoritative), populated
+	// each scrape by approximating observations from the classic answers*
+	// counters plus qa-latency (recursor) or from the latency EMA
+	// (authoritative).
+	rTimeHistogram prometheus.Histogram
+	rTimeCounts    map[string]float64
+
+	// divisors holds per-key unit conversions contributed by a custom
+	// metric mapping file, applied the same way the built-in
+	// microsecond->second latency conversion is.
+	divisors map[string]float64
+	// unknownStats exposes any statsMap entry not covered by gaugeDefs or
+	// counterVecDefs, so newer PowerDNS releases never silently drop data.
+	unknownStats *prometheus.GaugeVec
 }
 
 func newCounterVecMetric(serverType, metricName, docString string, labelNames []string) *prometheus.CounterVec {
@@ -119,6 +148,8 @@ func NewExporter(apiKey, serverType string, hostURL *url.URL) *Exporter {
 		counterVecDefs = dnsdistCounterVecDefs
 	}
 
+	gaugeDefs, counterVecDefs, divisors := applyCustomMappings(serverType, gaugeDefs, counterVecDefs)
+
 	for _, def := range gaugeDefs {
 		gaugeMetrics[def.id] = newGaugeMetric(serverType, def.name, def.desc)
 	}
@@ -127,6 +158,23 @@ func NewExporter(apiKey, serverType string, hostURL *url.URL) *Exporter {
 		counterVecMetrics[def.id] = newCounterVecMetric(serverType, def.name, def.desc, []string{def.label})
 	}
 
+	unknownStats := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: serverType,
+		Name:      "stat",
+		Help:      "Auto-discovered PowerDNS statistic not covered by a dedicated metric.",
+	}, []string{"name"})
+
+	var rTimeHistogram prometheus.Histogram
+	if nativeHistogramsEnabled {
+		switch serverType {
+		case "recursor":
+			rTimeHistogram = newRecursorRTimeNativeHistogram()
+		case "authoritative":
+			rTimeHistogram = newAuthoritativeLatencyNativeHistogram()
+		}
+	}
+
 	return &Exporter{
 		HostURL:    hostURL,
 		ServerType: serverType,
@@ -153,6 +201,10 @@ func NewExporter(apiKey, serverType string, hostURL *url.URL) *Exporter {
 		counterVecMetrics: counterVecMetrics,
 		gaugeDefs:         gaugeDefs,
 		counterVecDefs:    counterVecDefs,
+		client:            client,
+		rTimeHistogram:    rTimeHistogram,
+		divisors:          divisors,
+		unknownStats:      unknownStats,
 	}
 }
 
@@ -165,9 +217,13 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	for _, m := range e.gaugeMetrics {
 		ch <- m.Desc()
 	}
+	e.unknownStats.Describe(ch)
 	ch <- e.up.Desc()
 	ch <- e.totalScrapes.Desc()
 	ch <- e.jsonParseFailures.Desc()
+	if e.rTimeHistogram != nil {
+		ch <- e.rTimeHistogram.Desc()
+	}
 }
 
 // Collect fetches the stats from configured PowerDNS API URI and delivers them
@@ -194,7 +250,7 @@ func (e *Exporter) scrape(jsonStats chan<- []StatsEntry) {
 
 	var data []StatsEntry
 	url := apiURL(e.HostURL, apiStatsEndpoint)
-	err := getJSON(url, e.ApiKey, &data)
+	err := getJSON(e.client, url, e.ApiKey, &data)
 	if err != nil {
 		e.up.Set(0)
 		e.jsonParseFailures.Inc()
@@ -211,6 +267,7 @@ func (e *Exporter) resetMetrics() {
 	for _, m := range e.counterVecMetrics {
 		m.Reset()
 	}
+	e.unknownStats.Reset()
 }
 
 func (e *Exporter) collectMetrics(ch chan<- prometheus.Metric, statsMap map[string]float64) {
@@ -220,14 +277,58 @@ func (e *Exporter) collectMetrics(ch chan<- prometheus.Metric, statsMap map[stri
 	for _, m := range e.gaugeMetrics {
 		ch <- m
 	}
+	e.unknownStats.Collect(ch)
 
 	if e.ServerType == "recursor" {
-		h, err := makeRecursorRTimeHistogram(statsMap)
-		if err != nil {
-			log.Errorf("Could not create response time histogram: %v", err)
-			return
+		if e.rTimeHistogram != nil {
+			e.observeRTimeDeltas(statsMap)
+			ch <- e.rTimeHistogram
+		} else {
+			h, err := makeRecursorRTimeHistogram(statsMap)
+			if err != nil {
+				log.Errorf("Could not create response time histogram: %v", err)
+			} else {
+				ch <- h
+			}
 		}
-		ch <- h
+	}
+
+	if e.ServerType == "authoritative" && e.rTimeHistogram != nil {
+		if value, ok := statsMap["latency"]; ok {
+			e.rTimeHistogram.Observe(value / 1000000)
+			ch <- e.rTimeHistogram
+		}
+	}
+}
+
+// observeRTimeDeltas feeds the native recursor response-time histogram.
+// The classic answers* counters are themselves only 5 wide buckets, so
+// replaying their deltas at a fixed midpoint would carry no more
+// information than the classic powerdns_recursor_response_time_seconds
+// histogram built from those same counters. qa-latency, however,
+// is a continuous exponential moving average rather than a bucketed
+// counter: observing it every scrape gives the native histogram genuine
+// sub-bucket resolution the classic buckets alone cannot provide. The
+// answers* deltas are also replayed so the native histogram's sample
+// count and tail shape stay consistent with the answers* counters.
+func (e *Exporter) observeRTimeDeltas(statsMap map[string]float64) {
+	if e.rTimeCounts == nil {
+		e.rTimeCounts = make(map[string]float64, len(rTimeMidpoints))
+	}
+	for key, midpoint := range rTimeMidpoints {
+		value, ok := statsMap[key]
+		if !ok {
+			continue
+		}
+		delta := value - e.rTimeCounts[key]
+		e.rTimeCounts[key] = value
+		for i := 0; i < int(delta); i++ {
+			e.rTimeHistogram.Observe(midpoint)
+		}
+	}
+
+	if qaLatency, ok := statsMap["qa-latency"]; ok {
+		e.rTimeHistogram.Observe(qaLatency / 1000000)
 	}
 }
 
@@ -241,10 +342,17 @@ func (e *Exporter) setMetrics(jsonStats <-chan []StatsEntry) (statsMap map[strin
 		return
 	}
 
+	knownKeys := make(map[string]bool, len(e.gaugeDefs)+len(e.counterVecDefs))
+
 	for _, def := range e.gaugeDefs {
+		knownKeys[def.key] = true
 		if value, ok := statsMap[def.key]; ok {
-			// latency gauge needs to be converted from microseconds to seconds
-			if strings.HasSuffix(def.key, "latency") {
+			// A custom mapping's divide_by always wins; fall back to the
+			// built-in microseconds->seconds conversion for latency gauges
+			// that have no explicit mapping.
+			if divisor, ok := e.divisors[def.key]; ok {
+				value = value / divisor
+			} else if strings.HasSuffix(def.key, "latency") {
 				value = value / 1000000
 			}
 			e.gaugeMetrics[def.id].Set(value)
@@ -256,7 +364,11 @@ func (e *Exporter) setMetrics(jsonStats <-chan []StatsEntry) (statsMap map[strin
 
 	for _, def := range e.counterVecDefs {
 		for key, label := range def.labelMap {
+			knownKeys[key] = true
 			if value, ok := statsMap[key]; ok {
+				if divisor, ok := e.divisors[key]; ok {
+					value = value / divisor
+				}
 				e.counterVecMetrics[def.id].WithLabelValues(label).Set(value)
 			} else {
 				log.Errorf("Expected PowerDNS stats key not found: %s", key)
@@ -264,13 +376,24 @@ func (e *Exporter) setMetrics(jsonStats <-chan []StatsEntry) (statsMap map[strin
 			}
 		}
 	}
+
+	// Anything left over is a stat the built-in definitions don't know
+	// about yet (e.g. added by a newer PowerDNS release); surface it
+	// rather than silently dropping it.
+	for key, value := range statsMap {
+		if knownKeys[key] {
+			continue
+		}
+		e.unknownStats.WithLabelValues(key).Set(value)
+	}
+
 	return
 }
 
-func getServerInfo(hostURL *url.URL, apiKey string) (*ServerInfo, error) {
+func getServerInfo(httpClient *http.Client, hostURL *url.URL, apiKey string) (*ServerInfo, error) {
 	var info ServerInfo
 	url := apiURL(hostURL, apiInfoEndpoint)
-	err := getJSON(url, apiKey, &info)
+	err := getJSON(httpClient, url, apiKey, &info)
 	if err != nil {
 		return nil, err
 	}
@@ -278,14 +401,14 @@ func getServerInfo(hostURL *url.URL, apiKey string) (*ServerInfo, error) {
 	return &info, nil
 }
 
-func getJSON(url, apiKey string, data interface{}) error {
+func getJSON(httpClient *http.Client, url, apiKey string, data interface{}) error {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return err
 	}
 
 	req.Header.Add("X-API-Key", apiKey)
-	resp, err := client.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -313,36 +436,100 @@ func apiURL(hostURL *url.URL, path string) string {
 	return u.String()
 }
 
+// splitList splits a comma-separated flag value into its non-empty parts.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
 func main() {
 	var (
-		listenAddress = flag.String("listen-address", ":9130", "Address to listen on for web interface and telemetry.")
-		metricsPath   = flag.String("metric-path", "/metrics", "Path under which to expose metrics.")
-		apiURL        = flag.String("api-url", "http://localhost:8001/", "Base-URL of PowerDNS authoritative server/recursor API.")
-		apiKey        = flag.String("api-key", "", "PowerDNS API Key")
+		listenAddress     = flag.String("listen-address", ":9130", "Address to listen on for web interface and telemetry.")
+		metricsPath       = flag.String("metric-path", "/metrics", "Path under which to expose metrics.")
+		apiKey            = flag.String("api-key", "", "PowerDNS API Key used by the default module.")
+		serverType        = flag.String("server-type", "", "PowerDNS server type used by the default module (recursor, authoritative or dnsdist). Auto-detected against the target if empty.")
+		configFile        = flag.String("config.file", "", "Path to a YAML file defining named scrape modules for /probe (see README). A \"default\" module is always available, built from -api-key and -server-type unless the file defines its own \"default\" module.")
+		dnstapListen      = flag.String("dnstap-listen", "", "Unix socket path or TCP address to accept a dnstap stream on (disabled if empty).")
+		dnstapQtypes      = flag.String("dnstap.qtype-allowlist", "", "Comma-separated list of qtypes to report individually via dnstap; others are reported as \"other\" (empty allows all).")
+		dnstapRcodes      = flag.String("dnstap.rcode-allowlist", "", "Comma-separated list of rcodes to report individually via dnstap; others are reported as \"other\" (empty allows all).")
+		dnstapSubnet      = flag.String("dnstap.client-subnet-mode", "hash", "How to bound client subnet label cardinality for dnstap metrics: \"hash\" or \"truncate\".")
+		histogramNative   = flag.Bool("histogram.native", true, "Expose native (high-resolution) histograms for recursor response time and authoritative latency. When false, recursor response time falls back to the classic fixed-bucket histogram.")
+		metricMappingFile = flag.String("metric-mapping-file", "", "Path to a YAML file overriding or extending the built-in stat-to-metric definitions (disabled if empty). Reloaded on SIGHUP.")
+		collectorRings    = flag.Bool("collector.rings", false, "Enable the top-queries rings collector for recursor/dnsdist probes.")
+		ringsTopNFlag     = flag.Int("rings.top-n", 10, "Maximum number of entries to report per ring when the rings collector is enabled.")
 	)
 	flag.Parse()
 
-	hostURL, err := url.Parse(*apiURL)
-	if err != nil {
-		log.Fatalf("Error parsing api-url: %v", err)
+	nativeHistogramsEnabled = *histogramNative
+	ringsEnabled = *collectorRings
+	ringsTopN = *ringsTopNFlag
+
+	if *metricMappingFile != "" {
+		mappingCfg, err := LoadMetricMappingFile(*metricMappingFile)
+		if err != nil {
+			log.Fatalf("Error loading metric mapping file: %v", err)
+		}
+		setCustomMappings(mappingCfg)
 	}
 
-	server, err := getServerInfo(hostURL, *apiKey)
-	if err != nil {
-		log.Fatalf("Could not fetch PowerDNS server info: %v", err)
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			if *metricMappingFile == "" {
+				continue
+			}
+			mappingCfg, err := LoadMetricMappingFile(*metricMappingFile)
+			if err != nil {
+				log.Errorf("Could not reload metric mapping file: %v", err)
+				continue
+			}
+			setCustomMappings(mappingCfg)
+			log.Infof("Reloaded metric mapping file: %s", *metricMappingFile)
+		}
+	}()
+
+	defaultModule := Module{ServerType: *serverType, ApiKey: *apiKey}
+	cfg := &Config{Modules: map[string]Module{"default": defaultModule}}
+	if *configFile != "" {
+		loaded, err := LoadConfig(*configFile)
+		if err != nil {
+			log.Fatalf("Error loading config file: %v", err)
+		}
+		cfg = withDefaultModule(loaded, defaultModule)
+	}
+
+	if *dnstapListen != "" {
+		dnstapCollector := NewDnstapCollector(*dnstapListen, splitList(*dnstapQtypes), splitList(*dnstapRcodes), *dnstapSubnet)
+		prometheus.MustRegister(dnstapCollector)
+		go func() {
+			if err := dnstapCollector.Run(); err != nil {
+				log.Errorf("Dnstap listener stopped: %v", err)
+			}
+		}()
 	}
 
-	exporter := NewExporter(*apiKey, server.DaemonType, hostURL)
-	prometheus.MustRegister(exporter)
+	http.HandleFunc("/probe", func(w http.ResponseWriter, r *http.Request) {
+		probeHandler(w, r, cfg)
+	})
 
 	log.Infof("Starting Server: %s", *listenAddress)
-	http.Handle(*metricsPath, prometheus.Handler())
+	http.Handle(*metricsPath, promhttp.Handler())
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
              <head><title>PowerDNS Exporter</title></head>
              <body>
              <h1>PowerDNS Exporter</h1>
              <p><a href='` + *metricsPath + `'>Metrics</a></p>
+             <p>Use <code>/probe?target=http://host:8081/&module=&lt;name&gt;</code> to scrape a PowerDNS target.</p>
              </body>
              </html>`))
 	})
@@ -99,7 +99,7 @@ func TestParseServerInfo(t *testing.T) {
 
 	hostURL, _ := url.Parse(h.URL)
 
-	got, err := getServerInfo(hostURL, "12345")
+	got, err := getServerInfo(client, hostURL, "12345")
 	if err != nil {
 		t.Errorf("expected getServerInfo() to return no error, but got %v", err)
 	}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// probeHandler implements the blackbox_exporter-style multi-target pattern:
+// it builds a throwaway Exporter for the requested target/module pair,
+// scrapes it once and serves the result, instead of exposing one exporter
+// bound to a single PowerDNS server for the life of the process.
+func probeHandler(w http.ResponseWriter, r *http.Request, cfg *Config) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	moduleName := r.URL.Query().Get("module")
+	if moduleName == "" {
+		moduleName = "default"
+	}
+
+	module, ok := cfg.Modules[moduleName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown module %q", moduleName), http.StatusBadRequest)
+		return
+	}
+
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not parse target %q: %v", target, err), http.StatusBadRequest)
+		return
+	}
+
+	httpClient := newHTTPClient(module.Timeout)
+	if transport, ok := httpClient.Transport.(*http.Transport); ok {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: module.TLSConfig.InsecureSkipVerify}
+	}
+
+	serverType := module.ServerType
+	if serverType == "" {
+		info, err := getServerInfo(httpClient, targetURL, module.ApiKey)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not determine server type for %q: %v", target, err), http.StatusBadGateway)
+			return
+		}
+		serverType = info.DaemonType
+	}
+
+	exporter := NewExporter(module.ApiKey, serverType, targetURL)
+	exporter.client = httpClient
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+
+	if ringsEnabled && (serverType == "recursor" || serverType == "dnsdist") {
+		registry.MustRegister(NewRingsCollector(httpClient, targetURL, serverType, module.ApiKey, ringsTopN))
+	}
+
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
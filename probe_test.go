@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestProbeHandlerMissingTarget(t *testing.T) {
+	cfg := &Config{Modules: map[string]Module{"default": {}}}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/probe", nil)
+	probeHandler(rr, req, cfg)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for missing target, got %d", rr.Code)
+	}
+}
+
+func TestProbeHandlerUnknownModule(t *testing.T) {
+	cfg := &Config{Modules: map[string]Module{"default": {}}}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/probe?target=http://example.com/&module=missing", nil)
+	probeHandler(rr, req, cfg)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown module, got %d", rr.Code)
+	}
+}
+
+func TestProbeHandlerBadTargetURL(t *testing.T) {
+	cfg := &Config{Modules: map[string]Module{"default": {}}}
+
+	rr := httptest.NewRecorder()
+	// %25zz decodes to "%zz", which reaches url.Parse and fails there.
+	req := httptest.NewRequest("GET", "/probe?target=%25zz&module=default", nil)
+	probeHandler(rr, req, cfg)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unparseable target, got %d", rr.Code)
+	}
+}
+
+func TestProbeHandlerScrapesConfiguredServerType(t *testing.T) {
+	statsServer := newPowerDNS([]byte(`[{"name":"questions","type":"StatisticItem","value":"1"}]`))
+	defer statsServer.Close()
+
+	hostURL, _ := url.Parse(statsServer.URL)
+	cfg := &Config{Modules: map[string]Module{
+		"default": {ServerType: "recursor", ApiKey: "12345"},
+	}}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/probe?target="+url.QueryEscape(hostURL.String())+"&module=default", nil)
+	probeHandler(rr, req, cfg)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "powerdns_recursor_up") {
+		t.Errorf("expected response to contain the recursor up metric, got: %s", rr.Body.String())
+	}
+}
+
+func TestProbeHandlerAutoDetectsServerTypeFailure(t *testing.T) {
+	// A module with no ServerType makes probeHandler call getServerInfo
+	// against the target first; an unreachable target should surface as
+	// a 502, not a panic or a silently empty scrape.
+	cfg := &Config{Modules: map[string]Module{"default": {ApiKey: "12345"}}}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/probe?target=http://127.0.0.1:1/&module=default", nil)
+	probeHandler(rr, req, cfg)
+
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("expected 502 when server type auto-detection fails, got %d", rr.Code)
+	}
+}
+
+func TestWithDefaultModuleAddsMissingDefault(t *testing.T) {
+	defaultModule := Module{ServerType: "recursor", ApiKey: "secret"}
+	loaded := &Config{Modules: map[string]Module{"other": {ServerType: "dnsdist"}}}
+
+	cfg := withDefaultModule(loaded, defaultModule)
+
+	if got, ok := cfg.Modules["default"]; !ok || got != defaultModule {
+		t.Errorf("expected default module %#v to be injected, got %#v (present=%v)", defaultModule, got, ok)
+	}
+	if _, ok := cfg.Modules["other"]; !ok {
+		t.Error("expected modules loaded from the config file to still be present")
+	}
+}
+
+func TestWithDefaultModulePreservesConfigFileDefault(t *testing.T) {
+	fileDefault := Module{ServerType: "dnsdist"}
+	loaded := &Config{Modules: map[string]Module{"default": fileDefault}}
+
+	cfg := withDefaultModule(loaded, Module{ServerType: "recursor"})
+
+	if got := cfg.Modules["default"]; got != fileDefault {
+		t.Errorf("expected config file's own default module to win, got %#v", got)
+	}
+}
+
+func TestWithDefaultModuleHandlesNilModules(t *testing.T) {
+	defaultModule := Module{ServerType: "recursor"}
+	cfg := withDefaultModule(&Config{}, defaultModule)
+
+	if got, ok := cfg.Modules["default"]; !ok || got != defaultModule {
+		t.Errorf("expected default module to be injected into a nil Modules map, got %#v (present=%v)", got, ok)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+const (
+	recursorRingsEndpoint = "servers/localhost/rings/queries"
+	dnsdistRingsEndpoint  = "servers/localhost/rings"
+)
+
+// ringsEnabled mirrors node_exporter's collector-toggle pattern: the rings
+// collector is opt-in via --collector.rings, since it costs an extra scrape
+// per probe that most users won't need.
+var ringsEnabled = false
+
+// ringsTopN bounds how many entries of each ring are turned into metrics,
+// to keep the qname label's cardinality in check.
+var ringsTopN = 10
+
+// RingEntry is a single entry of a PowerDNS recursor or dnsdist "ring"
+// buffer, as returned by its rings endpoint: a query name/type pair
+// together with how many times it was seen.
+type RingEntry struct {
+	Name  string  `json:"name"`
+	Count float64 `json:"count"`
+}
+
+// RingsCollector fetches the top-N entries of a PowerDNS target's query
+// rings and exposes them as a bounded-cardinality gauge vec: ring counts
+// are a point-in-time snapshot of a fixed-size buffer, not a monotonic
+// total, and can go down as entries are evicted. It is registered
+// alongside the regular stats-scrape Exporter for a single probe, not
+// kept across scrapes.
+type RingsCollector struct {
+	hostURL    *url.URL
+	serverType string
+	apiKey     string
+	client     *http.Client
+	topN       int
+
+	topQueries *prometheus.GaugeVec
+}
+
+// NewRingsCollector returns an initialized RingsCollector for hostURL.
+func NewRingsCollector(httpClient *http.Client, hostURL *url.URL, serverType, apiKey string, topN int) *RingsCollector {
+	if topN <= 0 {
+		topN = ringsTopN
+	}
+	return &RingsCollector{
+		hostURL:    hostURL,
+		serverType: serverType,
+		apiKey:     apiKey,
+		client:     httpClient,
+		topN:       topN,
+		topQueries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: serverType,
+			Name:      "top_queries",
+			Help:      "Query counts for the top-N entries of the query ring, by qname and qtype.",
+		}, []string{"qname", "qtype"}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *RingsCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.topQueries.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *RingsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.topQueries.Reset()
+
+	entries, err := c.fetchRing()
+	if err != nil {
+		log.Errorf("Could not fetch PowerDNS rings: %v", err)
+		c.topQueries.Collect(ch)
+		return
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Count > entries[j].Count })
+	if len(entries) > c.topN {
+		entries = entries[:c.topN]
+	}
+
+	for _, e := range entries {
+		qname, qtype := splitRingName(e.Name)
+		c.topQueries.WithLabelValues(qname, qtype).Set(e.Count)
+	}
+
+	c.topQueries.Collect(ch)
+}
+
+func (c *RingsCollector) fetchRing() ([]RingEntry, error) {
+	endpoint := recursorRingsEndpoint
+	if c.serverType == "dnsdist" {
+		endpoint = dnsdistRingsEndpoint
+	}
+
+	var entries []RingEntry
+	url := apiURL(c.hostURL, endpoint)
+	if err := getJSON(c.client, url, c.apiKey, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// splitRingName splits a ring entry's "qname/qtype" name into its parts.
+func splitRingName(name string) (qname, qtype string) {
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		return name, ""
+	}
+	return name[:i], name[i+1:]
+}
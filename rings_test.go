@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestSplitRingName(t *testing.T) {
+	cases := []struct {
+		name      string
+		wantQname string
+		wantQtype string
+	}{
+		{"example.com./A", "example.com.", "A"},
+		{"sub.example.com./AAAA", "sub.example.com.", "AAAA"},
+		{"noqtype", "noqtype", ""},
+	}
+	for _, c := range cases {
+		qname, qtype := splitRingName(c.name)
+		if qname != c.wantQname || qtype != c.wantQtype {
+			t.Errorf("splitRingName(%q) = (%q, %q), want (%q, %q)", c.name, qname, qtype, c.wantQname, c.wantQtype)
+		}
+	}
+}
+
+func newRingsServer(body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+}
+
+func TestRingsCollectorExposesTopNAsGauge(t *testing.T) {
+	h := newRingsServer(`[
+		{"name":"a.example./A","count":1},
+		{"name":"b.example./A","count":5},
+		{"name":"c.example./AAAA","count":3}
+	]`)
+	defer h.Close()
+
+	hostURL, _ := url.Parse(h.URL)
+	c := NewRingsCollector(http.DefaultClient, hostURL, "recursor", "12345", 2)
+
+	ch := make(chan prometheus.Metric, 10)
+	c.Collect(ch)
+	close(ch)
+
+	var metrics []prometheus.Metric
+	for m := range ch {
+		metrics = append(metrics, m)
+	}
+	if got, want := len(metrics), 2; got != want {
+		t.Fatalf("expected top-%d entries, got %d metrics", want, got)
+	}
+
+	// GaugeVec.Collect emits series in no particular order, so compare
+	// the sorted values of the collected series.
+	var values []float64
+	for _, m := range metrics {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			t.Fatalf("could not write metric: %v", err)
+		}
+		if pb.GetGauge() == nil {
+			t.Fatal("expected top_queries to be exposed as a gauge, not a counter")
+		}
+		values = append(values, pb.GetGauge().GetValue())
+	}
+	sort.Float64s(values)
+	if got, want := values, []float64{3, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the two highest-count entries with values %v, got %v", want, got)
+	}
+}
+
+func TestRingsCollectorResetsBetweenScrapes(t *testing.T) {
+	h := newRingsServer(`[{"name":"a.example./A","count":9}]`)
+	defer h.Close()
+
+	hostURL, _ := url.Parse(h.URL)
+	c := NewRingsCollector(http.DefaultClient, hostURL, "recursor", "12345", 10)
+
+	ch := make(chan prometheus.Metric, 10)
+	c.Collect(ch)
+	close(ch)
+	for range ch {
+	}
+
+	h2 := newRingsServer(`[]`)
+	defer h2.Close()
+	hostURL2, _ := url.Parse(h2.URL)
+	c.hostURL = hostURL2
+
+	ch2 := make(chan prometheus.Metric, 10)
+	c.Collect(ch2)
+	close(ch2)
+
+	var count int
+	for range ch2 {
+		count++
+	}
+	if count != 0 {
+		t.Errorf("expected stale entries to be reset when the ring is empty, got %d leftover metrics", count)
+	}
+}